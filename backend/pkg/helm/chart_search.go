@@ -0,0 +1,339 @@
+package helm
+
+// Cross-repo chart search and version listing. Classic repositories are
+// served entirely from the RepoIndexCache -- no index is re-downloaded or
+// re-parsed to answer these requests. OCI registries have no index to
+// cache, so ListChartVersions lists their tags straight off the registry.
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+	zlog "github.com/rs/zerolog/log"
+	"helm.sh/helm/v3/pkg/cli"
+	"helm.sh/helm/v3/pkg/repo"
+)
+
+// ChartSummary is a single chart in search results: enough for the UI to
+// render a catalog card without downloading the chart itself.
+type ChartSummary struct {
+	Name        string `json:"name"`
+	Repo        string `json:"repo"`
+	Description string `json:"description"`
+	Version     string `json:"version"`
+	AppVersion  string `json:"appVersion"`
+	Icon        string `json:"icon"`
+	Home        string `json:"home"`
+	Deprecated  bool   `json:"deprecated"`
+}
+
+// ChartVersion is a single version of a chart, as returned by
+// ListChartVersions.
+type ChartVersion struct {
+	Version    string `json:"version"`
+	AppVersion string `json:"appVersion"`
+	Deprecated bool   `json:"deprecated"`
+}
+
+// compareVersions compares two semver strings, falling back to a
+// lexicographic comparison for versions that don't parse as semver.
+func compareVersions(a, b string) int {
+	va, errA := semver.NewVersion(a)
+	vb, errB := semver.NewVersion(b)
+
+	if errA != nil || errB != nil {
+		return strings.Compare(a, b)
+	}
+
+	return va.Compare(vb)
+}
+
+// sortedBySemverDesc returns a copy of versions sorted by Version
+// descending.
+func sortedBySemverDesc(versions repo.ChartVersions) repo.ChartVersions {
+	sorted := make(repo.ChartVersions, len(versions))
+	copy(sorted, versions)
+
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return compareVersions(sorted[i].Version, sorted[j].Version) > 0
+	})
+
+	return sorted
+}
+
+// matchesQuery reports whether query (already lowercased) is a substring of
+// chartName, the chart's description, or any of its keywords.
+func matchesQuery(query, chartName string, version *repo.ChartVersion) bool {
+	if query == "" {
+		return true
+	}
+
+	if strings.Contains(strings.ToLower(chartName), query) {
+		return true
+	}
+
+	if strings.Contains(strings.ToLower(version.Description), query) {
+		return true
+	}
+
+	for _, keyword := range version.Keywords {
+		if strings.Contains(strings.ToLower(keyword), query) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// SearchCharts searches the cached index of repos (or every cached
+// repository, when repos is empty) for charts whose name, keywords, or
+// description match query (case-insensitive substring). Results are
+// deduplicated to each chart's latest version, sorted by chart name and
+// then by that version descending, and capped at limit (a limit <= 0 means
+// no cap).
+//
+// OCI registries are intentionally absent from the results: the OCI
+// distribution spec has no index/catalog equivalent a registry is required
+// to expose, so there's no way to discover what charts a registry holds
+// without already knowing their names. Use ListChartVersions against a
+// known chart name to query an OCI registry instead.
+func SearchCharts(cache *RepoIndexCache, query string, repos []string, includeDeprecated bool, limit int) []ChartSummary {
+	query = strings.ToLower(query)
+
+	wanted := make(map[string]bool, len(repos))
+	for _, name := range repos {
+		wanted[name] = true
+	}
+
+	var results []ChartSummary
+
+	for repoName, index := range cache.All() {
+		if len(wanted) > 0 && !wanted[repoName] {
+			continue
+		}
+
+		for chartName, versions := range index.Entries {
+			latest := sortedBySemverDesc(versions)
+			if len(latest) == 0 {
+				continue
+			}
+
+			newest := latest[0]
+
+			if !includeDeprecated && newest.Deprecated {
+				continue
+			}
+
+			if !matchesQuery(query, chartName, newest) {
+				continue
+			}
+
+			results = append(results, ChartSummary{
+				Name:        chartName,
+				Repo:        repoName,
+				Description: newest.Description,
+				Version:     newest.Version,
+				AppVersion:  newest.AppVersion,
+				Icon:        newest.Icon,
+				Home:        newest.Home,
+				Deprecated:  newest.Deprecated,
+			})
+		}
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Name != results[j].Name {
+			return results[i].Name < results[j].Name
+		}
+
+		return compareVersions(results[i].Version, results[j].Version) > 0
+	})
+
+	if limit > 0 && len(results) > limit {
+		results = results[:limit]
+	}
+
+	return results
+}
+
+// ListChartVersions returns every version of chartName in repoName's cached
+// index, sorted by semver descending. If repoName isn't a classic
+// repository with a cached index, it falls back to treating repoName as a
+// registered OCI registry and lists chartName's tags straight off the
+// registry, since OCI has no index to cache.
+func ListChartVersions(cache *RepoIndexCache, settings *cli.EnvSettings, repoName, chartName string) ([]ChartVersion, error) {
+	index, ok := cache.Get(repoName)
+	if !ok {
+		return listOCIChartVersionsAsChartVersions(settings, repoName, chartName)
+	}
+
+	versions, ok := index.Entries[chartName]
+	if !ok {
+		return nil, fmt.Errorf("chart %q not found in repository %q", chartName, repoName)
+	}
+
+	sorted := sortedBySemverDesc(versions)
+
+	result := make([]ChartVersion, 0, len(sorted))
+	for _, version := range sorted {
+		result = append(result, ChartVersion{
+			Version:    version.Version,
+			AppVersion: version.AppVersion,
+			Deprecated: version.Deprecated,
+		})
+	}
+
+	return result, nil
+}
+
+// listOCIChartVersionsAsChartVersions looks up repoName among the
+// registered OCI registries and lists chartName's tags as ChartVersions.
+// OCI tags carry no app version or deprecation metadata, so those fields
+// are left zero.
+func listOCIChartVersionsAsChartVersions(settings *cli.EnvSettings, repoName, chartName string) ([]ChartVersion, error) {
+	entry, ok, err := findOCIRegistry(settings, repoName)
+	if err != nil {
+		return nil, err
+	}
+
+	if !ok {
+		return nil, fmt.Errorf("repository %q has no cached index; refresh it first", repoName)
+	}
+
+	creds, err := loadCredentials(settings, repoName)
+	if err != nil {
+		return nil, err
+	}
+
+	tags, err := listOCIChartVersions(entry.URL, chartName, creds)
+	if err != nil {
+		return nil, fmt.Errorf("chart %q not found in registry %q: %w", chartName, repoName, err)
+	}
+
+	result := make([]ChartVersion, 0, len(tags))
+	for _, tag := range tags {
+		result = append(result, ChartVersion{Version: tag})
+	}
+
+	sort.SliceStable(result, func(i, j int) bool {
+		return compareVersions(result[i].Version, result[j].Version) > 0
+	})
+
+	return result, nil
+}
+
+// SearchChartsResponse is the body of GET /helm/charts.
+type SearchChartsResponse struct {
+	Charts []ChartSummary `json:"charts"`
+}
+
+// Search charts across every cached repository, or a subset named by the
+// repeated/comma-separated "repo" query parameter. Supports "q" (substring
+// match against name, description, keywords), "deprecated" (defaults to
+// true) and "limit" (defaults to unlimited).
+func (h *Handler) SearchCharts(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query().Get("q")
+
+	var repos []string
+
+	for _, reposParam := range r.URL.Query()["repo"] {
+		if reposParam != "" {
+			repos = append(repos, strings.Split(reposParam, ",")...)
+		}
+	}
+
+	includeDeprecated := true
+
+	if deprecatedParam := r.URL.Query().Get("deprecated"); deprecatedParam != "" {
+		parsed, err := strconv.ParseBool(deprecatedParam)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		includeDeprecated = parsed
+	}
+
+	limit := 0
+
+	if limitParam := r.URL.Query().Get("limit"); limitParam != "" {
+		parsed, err := strconv.Atoi(limitParam)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		limit = parsed
+	}
+
+	response := SearchChartsResponse{
+		Charts: SearchCharts(h.IndexCache, query, repos, includeDeprecated, limit),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		zlog.Error().Err(err).Str("action", "search_charts").Msg("failed to encode response")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// ListChartVersionsResponse is the body of
+// GET /helm/charts/{repo}/{chart}/versions.
+type ListChartVersionsResponse struct {
+	Versions []ChartVersion `json:"versions"`
+}
+
+// parseChartVersionsPath extracts the repo and chart names from a
+// /helm/charts/{repo}/{chart}/versions path. There's no router wired into
+// this package, so the segments are parsed by hand rather than via path
+// variables.
+func parseChartVersionsPath(path string) (repoName, chartName string, ok bool) {
+	const prefix = "/helm/charts/"
+
+	const suffix = "/versions"
+
+	if !strings.HasPrefix(path, prefix) || !strings.HasSuffix(path, suffix) {
+		return "", "", false
+	}
+
+	middle := strings.TrimSuffix(strings.TrimPrefix(path, prefix), suffix)
+
+	parts := strings.SplitN(middle, "/", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", false
+	}
+
+	return parts[0], parts[1], true
+}
+
+// List the versions of a single chart in a single repository.
+func (h *Handler) ListChartVersions(w http.ResponseWriter, r *http.Request) {
+	repoName, chartName, ok := parseChartVersionsPath(r.URL.Path)
+	if !ok {
+		http.Error(w, "expected path /helm/charts/{repo}/{chart}/versions", http.StatusBadRequest)
+		return
+	}
+
+	versions, err := ListChartVersions(h.IndexCache, h.EnvSettings, repoName, chartName)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	response := ListChartVersionsResponse{Versions: versions}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		zlog.Error().Err(err).Str("action", "list_chart_versions").Msg("failed to encode response")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,81 @@
+package helm
+
+import (
+	"testing"
+
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/repo"
+)
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		name   string
+		a, b   string
+		wantGT bool
+	}{
+		{name: "semver greater", a: "1.2.0", b: "1.1.0", wantGT: true},
+		{name: "semver lesser", a: "1.0.0", b: "1.1.0", wantGT: false},
+		{name: "non-semver falls back to lexicographic", a: "b", b: "a", wantGT: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := compareVersions(tt.a, tt.b) > 0
+			if got != tt.wantGT {
+				t.Errorf("compareVersions(%q, %q) > 0 = %v, want %v", tt.a, tt.b, got, tt.wantGT)
+			}
+		})
+	}
+}
+
+func TestSortedBySemverDesc(t *testing.T) {
+	versions := repo.ChartVersions{
+		{Metadata: &chart.Metadata{Version: "1.0.0"}},
+		{Metadata: &chart.Metadata{Version: "2.0.0"}},
+		{Metadata: &chart.Metadata{Version: "1.5.0"}},
+	}
+
+	sorted := sortedBySemverDesc(versions)
+
+	want := []string{"2.0.0", "1.5.0", "1.0.0"}
+	for i, version := range sorted {
+		if version.Version != want[i] {
+			t.Errorf("sorted[%d].Version = %q, want %q", i, version.Version, want[i])
+		}
+	}
+
+	// The input slice itself must be untouched.
+	if versions[0].Version != "1.0.0" {
+		t.Errorf("input versions were mutated: %v", versions)
+	}
+}
+
+func TestMatchesQuery(t *testing.T) {
+	version := &repo.ChartVersion{
+		Metadata: &chart.Metadata{
+			Name:        "nginx",
+			Description: "A web server",
+			Keywords:    []string{"http", "web"},
+		},
+	}
+
+	tests := []struct {
+		name  string
+		query string
+		want  bool
+	}{
+		{name: "empty query matches everything", query: "", want: true},
+		{name: "matches chart name", query: "ngi", want: true},
+		{name: "matches description", query: "web server", want: true},
+		{name: "matches keyword", query: "http", want: true},
+		{name: "no match", query: "database", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := matchesQuery(tt.query, "nginx", version); got != tt.want {
+				t.Errorf("matchesQuery(%q, ...) = %v, want %v", tt.query, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,286 @@
+package helm
+
+// Repository credentials (basic/bearer auth plus mTLS material) are kept
+// out of repository.yaml and oci_registries.yaml entirely, since neither
+// repo.File's nor our own marshalling has any notion of secrecy. Instead
+// they're sealed with AES-GCM and stored in a sidecar file, keyed by
+// repository name.
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v3"
+	"helm.sh/helm/v3/pkg/cli"
+	"helm.sh/helm/v3/pkg/repo"
+)
+
+const credentialsFileName = "repo_credentials.enc.yaml"
+
+// encryptionKeyEnvVar names the environment variable holding Headlamp's
+// server-wide encryption key. Operators must set it to use authenticated
+// repositories; credentials can't be sealed without it.
+const encryptionKeyEnvVar = "HEADLAMP_CONFIG_ENCRYPTION_KEY"
+
+// RepoCredentials holds the authentication material for a single
+// repository or OCI registry.
+type RepoCredentials struct {
+	Username              string `yaml:"username,omitempty"`
+	Password              string `yaml:"password,omitempty"`
+	BearerToken           string `yaml:"bearerToken,omitempty"`
+	PassCredentialsAll    bool   `yaml:"passCredentialsAll,omitempty"`
+	CAFile                string `yaml:"caFile,omitempty"`
+	CertFile              string `yaml:"certFile,omitempty"`
+	KeyFile               string `yaml:"keyFile,omitempty"`
+	InsecureSkipTLSverify bool   `yaml:"insecureSkipTLSverify,omitempty"`
+}
+
+// isEmpty reports whether creds carries no credential material at all.
+func (c *RepoCredentials) isEmpty() bool {
+	return *c == RepoCredentials{}
+}
+
+// credentialsFromRequest extracts the credential fields of an
+// AddUpdateRepoRequest.
+func credentialsFromRequest(request AddUpdateRepoRequest) *RepoCredentials {
+	return &RepoCredentials{
+		Username:              request.Username,
+		Password:              request.Password,
+		BearerToken:           request.BearerToken,
+		PassCredentialsAll:    request.PassCredentialsAll,
+		CAFile:                request.CAFile,
+		CertFile:              request.CertFile,
+		KeyFile:               request.KeyFile,
+		InsecureSkipTLSverify: request.InsecureSkipTLSverify,
+	}
+}
+
+// encryptedCredentialsFile is the on-disk shape of the credentials sidecar
+// file: repository name to base64-encoded, AES-GCM sealed RepoCredentials.
+type encryptedCredentialsFile struct {
+	Credentials map[string]string `yaml:"credentials"`
+}
+
+func credentialsPath(settings *cli.EnvSettings) string {
+	return filepath.Join(filepath.Dir(settings.RepositoryConfig), credentialsFileName)
+}
+
+func encryptionKey() ([]byte, error) {
+	secret := os.Getenv(encryptionKeyEnvVar)
+	if secret == "" {
+		return nil, fmt.Errorf("%s is not set; cannot store repository credentials", encryptionKeyEnvVar)
+	}
+
+	key := sha256.Sum256([]byte(secret))
+
+	return key[:], nil
+}
+
+func sealCredentials(creds *RepoCredentials) (string, error) {
+	key, err := encryptionKey()
+	if err != nil {
+		return "", err
+	}
+
+	plaintext, err := yaml.Marshal(creds)
+	if err != nil {
+		return "", err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return "", err
+	}
+
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+
+	sealed := gcm.Seal(nonce, nonce, plaintext, nil)
+
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func openCredentials(encoded string) (*RepoCredentials, error) {
+	key, err := encryptionKey()
+	if err != nil {
+		return nil, err
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	nonceSize := gcm.NonceSize()
+	if len(sealed) < nonceSize {
+		return nil, errors.New("malformed credentials ciphertext")
+	}
+
+	nonce, ciphertext := sealed[:nonceSize], sealed[nonceSize:]
+
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	creds := &RepoCredentials{}
+	if err := yaml.Unmarshal(plaintext, creds); err != nil {
+		return nil, err
+	}
+
+	return creds, nil
+}
+
+func loadCredentialsFile(settings *cli.EnvSettings) (*encryptedCredentialsFile, error) {
+	data, err := os.ReadFile(credentialsPath(settings))
+	if os.IsNotExist(err) {
+		return &encryptedCredentialsFile{Credentials: map[string]string{}}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	file := &encryptedCredentialsFile{}
+	if err := yaml.Unmarshal(data, file); err != nil {
+		return nil, err
+	}
+
+	if file.Credentials == nil {
+		file.Credentials = map[string]string{}
+	}
+
+	return file, nil
+}
+
+func writeCredentialsFile(settings *cli.EnvSettings, file *encryptedCredentialsFile) error {
+	data, err := yaml.Marshal(file)
+	if err != nil {
+		return err
+	}
+
+	path := credentialsPath(settings)
+
+	if err := os.MkdirAll(filepath.Dir(path), defaultNewConfigFolderMode); err != nil {
+		return err
+	}
+
+	return atomicWriteFile(path, defaultNewConfigFileMode, data)
+}
+
+// withCredentialsLock acquires an exclusive lock on the credentials sidecar
+// file, hands the parsed file to fn, and -- provided fn succeeds --
+// atomically writes it back.
+func withCredentialsLock(ctx context.Context, settings *cli.EnvSettings, fn func(*encryptedCredentialsFile) error) error {
+	return withFileLock(ctx, credentialsPath(settings), func() error {
+		file, err := loadCredentialsFile(settings)
+		if err != nil {
+			return err
+		}
+
+		if err := fn(file); err != nil {
+			return err
+		}
+
+		return writeCredentialsFile(settings, file)
+	})
+}
+
+// storeCredentials seals and persists creds for the named repository. An
+// empty creds removes any stored credentials for that name instead.
+func storeCredentials(settings *cli.EnvSettings, name string, creds *RepoCredentials) error {
+	lockCtx, cancel := context.WithTimeout(context.Background(), timeoutForLock)
+	defer cancel()
+
+	return withCredentialsLock(lockCtx, settings, func(file *encryptedCredentialsFile) error {
+		if creds == nil || creds.isEmpty() {
+			delete(file.Credentials, name)
+			return nil
+		}
+
+		sealed, err := sealCredentials(creds)
+		if err != nil {
+			return err
+		}
+
+		file.Credentials[name] = sealed
+
+		return nil
+	})
+}
+
+// loadCredentials returns the stored credentials for name, or nil if none
+// are stored.
+func loadCredentials(settings *cli.EnvSettings, name string) (*RepoCredentials, error) {
+	file, err := loadCredentialsFile(settings)
+	if err != nil {
+		return nil, err
+	}
+
+	encoded, ok := file.Credentials[name]
+	if !ok {
+		return nil, nil
+	}
+
+	return openCredentials(encoded)
+}
+
+// deleteCredentials removes any stored credentials for name.
+func deleteCredentials(settings *cli.EnvSettings, name string) error {
+	lockCtx, cancel := context.WithTimeout(context.Background(), timeoutForLock)
+	defer cancel()
+
+	return withCredentialsLock(lockCtx, settings, func(file *encryptedCredentialsFile) error {
+		delete(file.Credentials, name)
+		return nil
+	})
+}
+
+// withCredentials returns a copy of entry merged with any stored
+// credentials for entry.Name, for use when actually talking to the
+// repository. The copy is never persisted back to repository.yaml.
+func withCredentials(entry *repo.Entry, settings *cli.EnvSettings) (*repo.Entry, error) {
+	creds, err := loadCredentials(settings, entry.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	if creds == nil {
+		return entry, nil
+	}
+
+	merged := *entry
+	merged.Username = creds.Username
+	merged.Password = creds.Password
+	merged.PassCredentialsAll = creds.PassCredentialsAll
+	merged.CAFile = creds.CAFile
+	merged.CertFile = creds.CertFile
+	merged.KeyFile = creds.KeyFile
+	merged.InsecureSkipTLSverify = creds.InsecureSkipTLSverify
+
+	return &merged, nil
+}
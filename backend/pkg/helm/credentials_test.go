@@ -0,0 +1,46 @@
+package helm
+
+import (
+	"os"
+	"testing"
+)
+
+func TestSealOpenCredentialsRoundTrip(t *testing.T) {
+	t.Setenv(encryptionKeyEnvVar, "test-encryption-key")
+
+	creds := &RepoCredentials{
+		Username:    "alice",
+		Password:    "s3cr3t",
+		BearerToken: "token-value",
+	}
+
+	sealed, err := sealCredentials(creds)
+	if err != nil {
+		t.Fatalf("sealCredentials returned error: %v", err)
+	}
+
+	opened, err := openCredentials(sealed)
+	if err != nil {
+		t.Fatalf("openCredentials returned error: %v", err)
+	}
+
+	if *opened != *creds {
+		t.Errorf("openCredentials = %+v, want %+v", *opened, *creds)
+	}
+}
+
+func TestSealCredentialsMissingEncryptionKey(t *testing.T) {
+	os.Unsetenv(encryptionKeyEnvVar)
+
+	if _, err := sealCredentials(&RepoCredentials{Username: "alice"}); err == nil {
+		t.Error("expected an error when the encryption key env var isn't set")
+	}
+}
+
+func TestOpenCredentialsMalformedCiphertext(t *testing.T) {
+	t.Setenv(encryptionKeyEnvVar, "test-encryption-key")
+
+	if _, err := openCredentials("not-valid-base64-or-too-short"); err == nil {
+		t.Error("expected an error for malformed ciphertext")
+	}
+}
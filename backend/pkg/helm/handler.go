@@ -0,0 +1,91 @@
+package helm
+
+// Handler and its constructor tie together the pieces the rest of this
+// package only defines in isolation: the repository/credentials state on
+// disk, the in-memory chart index cache, and the HTTP routes that expose
+// them.
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"helm.sh/helm/v3/pkg/cli"
+)
+
+// Handler serves the HTTP endpoints for managing chart repositories and
+// OCI registries, their credentials, and searching their cached chart
+// indexes.
+type Handler struct {
+	EnvSettings *cli.EnvSettings
+	IndexCache  *RepoIndexCache
+}
+
+// NewHandler creates a Handler wired to settings and starts IndexCache's
+// background refresh goroutine at refreshInterval (a zero interval picks
+// DefaultRefreshInterval). The goroutine stops when ctx is cancelled.
+func NewHandler(ctx context.Context, settings *cli.EnvSettings, refreshInterval time.Duration) *Handler {
+	cache := NewRepoIndexCache()
+	cache.Run(ctx, settings, refreshInterval)
+
+	return &Handler{
+		EnvSettings: settings,
+		IndexCache:  cache,
+	}
+}
+
+// RegisterRoutes wires every helm repository/chart endpoint onto mux.
+func (h *Handler) RegisterRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("/helm/repositories", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			h.ListRepo(w, r)
+		case http.MethodPost:
+			h.AddRepo(w, r)
+		case http.MethodPut:
+			h.UpdateRepository(w, r)
+		case http.MethodDelete:
+			h.RemoveRepo(w, r)
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+
+	mux.HandleFunc("/helm/repositories/credentials", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		h.UpdateRepoCredentials(w, r)
+	})
+
+	mux.HandleFunc("/helm/repositories/refresh", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		h.RefreshRepositories(w, r)
+	})
+
+	mux.HandleFunc("/helm/charts", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		h.SearchCharts(w, r)
+	})
+
+	// /helm/charts/{repo}/{chart}/versions; parsed by hand in
+	// parseChartVersionsPath since there's no path-variable router here.
+	mux.HandleFunc("/helm/charts/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		h.ListChartVersions(w, r)
+	})
+}
@@ -0,0 +1,194 @@
+package helm
+
+// Locking helpers shared by every mutation of the repository config, the
+// OCI registries sidecar, and the credentials sidecar. Each caller gets a
+// single flock-backed critical section plus an atomic temp-file-and-rename
+// write, so no call site has to re-implement the lock/defer-unlock/
+// error-log dance or risk leaving a half-written file behind on a crash.
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gofrs/flock"
+	zlog "github.com/rs/zerolog/log"
+	"helm.sh/helm/v3/pkg/cli"
+	"helm.sh/helm/v3/pkg/repo"
+)
+
+// repoLockPath derives a sibling ".lock" path for configPath, e.g.
+// repository.yaml -> repository.lock.
+func repoLockPath(configPath string) string {
+	ext := filepath.Ext(configPath)
+
+	if len(ext) > 0 && len(ext) < len(configPath) {
+		return strings.Replace(configPath, ext, ".lock", 1)
+	}
+
+	return configPath + ".lock"
+}
+
+// Uses a file lock like the helm tool.
+func lockRepositoryFile(lockCtx context.Context, repositoryConfig string) (bool, *flock.Flock, error) {
+	fileLock := flock.New(repoLockPath(repositoryConfig))
+
+	locked, err := fileLock.TryLockContext(lockCtx, time.Second)
+
+	return locked, fileLock, err
+}
+
+// lockRepositoryFileShared takes a shared (read) lock, so it can run
+// alongside other readers but still excludes a concurrent writer -- this is
+// what keeps reads from observing a half-written file mid-rename.
+func lockRepositoryFileShared(lockCtx context.Context, repositoryConfig string) (bool, *flock.Flock, error) {
+	fileLock := flock.New(repoLockPath(repositoryConfig))
+
+	locked, err := fileLock.TryRLockContext(lockCtx, time.Second)
+
+	return locked, fileLock, err
+}
+
+// withFileLock acquires an exclusive flock derived from path, invokes fn,
+// and always releases the lock afterward.
+func withFileLock(ctx context.Context, path string, fn func() error) error {
+	locked, fileLock, err := lockRepositoryFile(ctx, path)
+	if err == nil && locked {
+		defer func() {
+			if err := fileLock.Unlock(); err != nil {
+				zlog.Error().Err(err).Str("path", path).Msg("failed to unlock file")
+			}
+		}()
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return fn()
+}
+
+// atomicWriteFile writes data to path by first writing to a temp file in
+// the same directory, fsyncing it, and renaming it into place, so readers
+// never observe a partially written file.
+func atomicWriteFile(path string, perm os.FileMode, data []byte) error {
+	dir := filepath.Dir(path)
+
+	tmp, err := os.CreateTemp(dir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+
+	tmpPath := tmp.Name()
+	defer os.Remove(tmpPath) // no-op once the rename below succeeds
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Chmod(tmpPath, perm); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpPath, path)
+}
+
+// writeRepoFileAtomically persists repoFile via its own WriteFile method,
+// but into a temp file that's fsynced and renamed into place rather than
+// written directly, so a crash mid-write can't corrupt repository.yaml.
+func writeRepoFileAtomically(repoFile *repo.File, path string, perm os.FileMode) error {
+	dir := filepath.Dir(path)
+
+	tmp, err := os.CreateTemp(dir, ".repository-*.yaml.tmp")
+	if err != nil {
+		return err
+	}
+
+	tmpPath := tmp.Name()
+	tmp.Close()
+
+	defer os.Remove(tmpPath) // no-op once the rename below succeeds
+
+	if err := repoFile.WriteFile(tmpPath, perm); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(tmpPath, os.O_RDWR, perm)
+	if err != nil {
+		return err
+	}
+
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpPath, path)
+}
+
+// withRepoLock loads settings.RepositoryConfig, acquires an exclusive file
+// lock, hands the parsed repo.File to fn, and -- provided fn succeeds --
+// atomically writes it back. The lock is always released.
+func withRepoLock(ctx context.Context, settings *cli.EnvSettings, fn func(*repo.File) error) error {
+	if err := createFileIfNotThere(settings.RepositoryConfig); err != nil {
+		return err
+	}
+
+	return withFileLock(ctx, settings.RepositoryConfig, func() error {
+		repoFile, err := repo.LoadFile(settings.RepositoryConfig)
+		if err != nil {
+			return err
+		}
+
+		if err := fn(repoFile); err != nil {
+			return err
+		}
+
+		return writeRepoFileAtomically(repoFile, settings.RepositoryConfig, defaultNewConfigFileMode)
+	})
+}
+
+// withRepoReadLock is like withRepoLock but takes a shared lock and never
+// writes the file back; fn must treat the repo.File it's given as
+// read-only.
+func withRepoReadLock(ctx context.Context, settings *cli.EnvSettings, fn func(*repo.File) error) error {
+	if err := createFileIfNotThere(settings.RepositoryConfig); err != nil {
+		return err
+	}
+
+	locked, fileLock, err := lockRepositoryFileShared(ctx, settings.RepositoryConfig)
+	if err == nil && locked {
+		defer func() {
+			if err := fileLock.Unlock(); err != nil {
+				zlog.Error().Err(err).Str("path", settings.RepositoryConfig).Msg("failed to unlock file")
+			}
+		}()
+	}
+
+	if err != nil {
+		return err
+	}
+
+	repoFile, err := repo.LoadFile(settings.RepositoryConfig)
+	if err != nil {
+		return err
+	}
+
+	return fn(repoFile)
+}
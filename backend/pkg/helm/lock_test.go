@@ -0,0 +1,136 @@
+package helm
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"helm.sh/helm/v3/pkg/cli"
+	"helm.sh/helm/v3/pkg/repo"
+)
+
+var errTestFailure = errors.New("test failure")
+
+func TestAtomicWriteFileCreatesFileWithContent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.yaml")
+
+	if err := atomicWriteFile(path, defaultNewConfigFileMode, []byte("hello")); err != nil {
+		t.Fatalf("atomicWriteFile returned error: %v", err)
+	}
+
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read back written file: %v", err)
+	}
+
+	if string(got) != "hello" {
+		t.Errorf("file content = %q, want %q", got, "hello")
+	}
+}
+
+func TestAtomicWriteFileLeavesNoTempFileBehind(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "data.yaml")
+
+	if err := atomicWriteFile(path, defaultNewConfigFileMode, []byte("hello")); err != nil {
+		t.Fatalf("atomicWriteFile returned error: %v", err)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("failed to read dir: %v", err)
+	}
+
+	if len(entries) != 1 || entries[0].Name() != "data.yaml" {
+		t.Errorf("dir entries = %v, want only data.yaml", entries)
+	}
+}
+
+func TestWriteRepoFileAtomically(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "repositories.yaml")
+
+	repoFile := repo.NewFile()
+	repoFile.Update(&repo.Entry{Name: "stable", URL: "https://charts.example.com"})
+
+	if err := writeRepoFileAtomically(repoFile, path, defaultNewConfigFileMode); err != nil {
+		t.Fatalf("writeRepoFileAtomically returned error: %v", err)
+	}
+
+	loaded, err := repo.LoadFile(path)
+	if err != nil {
+		t.Fatalf("repo.LoadFile returned error: %v", err)
+	}
+
+	if len(loaded.Repositories) != 1 || loaded.Repositories[0].Name != "stable" {
+		t.Errorf("loaded repositories = %+v, want a single \"stable\" entry", loaded.Repositories)
+	}
+}
+
+func TestWithRepoLockPersistsChanges(t *testing.T) {
+	settings := &cli.EnvSettings{RepositoryConfig: filepath.Join(t.TempDir(), "repositories.yaml")}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err := withRepoLock(ctx, settings, func(repoFile *repo.File) error {
+		repoFile.Update(&repo.Entry{Name: "stable", URL: "https://charts.example.com"})
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("withRepoLock returned error: %v", err)
+	}
+
+	var names []string
+
+	err = withRepoReadLock(ctx, settings, func(repoFile *repo.File) error {
+		for _, entry := range repoFile.Repositories {
+			names = append(names, entry.Name)
+		}
+
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("withRepoReadLock returned error: %v", err)
+	}
+
+	if len(names) != 1 || names[0] != "stable" {
+		t.Errorf("repositories after withRepoLock = %v, want [stable]", names)
+	}
+}
+
+func TestWithRepoLockDoesNotPersistOnError(t *testing.T) {
+	settings := &cli.EnvSettings{RepositoryConfig: filepath.Join(t.TempDir(), "repositories.yaml")}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	wantErr := errTestFailure
+
+	err := withRepoLock(ctx, settings, func(repoFile *repo.File) error {
+		repoFile.Update(&repo.Entry{Name: "stable", URL: "https://charts.example.com"})
+		return wantErr
+	})
+	if err != wantErr {
+		t.Fatalf("withRepoLock returned error %v, want %v", err, wantErr)
+	}
+
+	var names []string
+
+	err = withRepoReadLock(ctx, settings, func(repoFile *repo.File) error {
+		for _, entry := range repoFile.Repositories {
+			names = append(names, entry.Name)
+		}
+
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("withRepoReadLock returned error: %v", err)
+	}
+
+	if len(names) != 0 {
+		t.Errorf("repositories after a failed withRepoLock call = %v, want none", names)
+	}
+}
@@ -0,0 +1,487 @@
+package helm
+
+// OCI registries have no index.yaml, so they don't fit the repo.Entry/
+// repo.File model classic HTTP chart repositories use. They're tracked in a
+// small sibling config file instead, next to repository.yaml.
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	zlog "github.com/rs/zerolog/log"
+	"gopkg.in/yaml.v3"
+	"helm.sh/helm/v3/pkg/cli"
+	"helm.sh/helm/v3/pkg/registry"
+)
+
+// ociScheme is the URL scheme that identifies an OCI registry reference,
+// e.g. oci://registry.example.com/charts.
+const ociScheme = "oci://"
+
+const ociRegistriesFileName = "oci_registries.yaml"
+
+// isOCIRepository reports whether url points at an OCI registry rather than
+// a classic HTTP chart repository.
+func isOCIRepository(url string) bool {
+	return strings.HasPrefix(url, ociScheme)
+}
+
+// ociRegistryHost strips the oci:// scheme and any repository path off url,
+// leaving just the registry host, e.g. oci://registry.example.com/charts ->
+// registry.example.com.
+func ociRegistryHost(url string) string {
+	host := strings.TrimPrefix(url, ociScheme)
+
+	return strings.SplitN(host, "/", 2)[0]
+}
+
+// ociChartRef builds the reference a registry.Client call expects for
+// chartName hosted at registryURL, e.g. oci://host/charts + "nginx" ->
+// host/charts/nginx.
+func ociChartRef(registryURL, chartName string) string {
+	ref := strings.TrimSuffix(strings.TrimPrefix(registryURL, ociScheme), "/")
+
+	return ref + "/" + chartName
+}
+
+// OCIRegistryEntry is a single OCI registry tracked alongside the classic
+// repo.File entries.
+type OCIRegistryEntry struct {
+	Name string `json:"name" yaml:"name"`
+	URL  string `json:"url" yaml:"url"`
+}
+
+// ociRegistryFile is the on-disk shape of oci_registries.yaml.
+type ociRegistryFile struct {
+	Registries []*OCIRegistryEntry `yaml:"registries"`
+}
+
+// ociRegistriesPath returns the path to the OCI registries file, stored
+// alongside the classic repository config.
+func ociRegistriesPath(settings *cli.EnvSettings) string {
+	return filepath.Join(filepath.Dir(settings.RepositoryConfig), ociRegistriesFileName)
+}
+
+// loadOCIRegistryFile reads the OCI registries file, returning an empty one
+// if it doesn't exist yet.
+func loadOCIRegistryFile(settings *cli.EnvSettings) (*ociRegistryFile, error) {
+	path := ociRegistriesPath(settings)
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return &ociRegistryFile{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	file := &ociRegistryFile{}
+	if err := yaml.Unmarshal(data, file); err != nil {
+		return nil, err
+	}
+
+	return file, nil
+}
+
+// withOCIRegistryLock acquires an exclusive lock on the OCI registries
+// file, hands the parsed file to fn, and -- provided fn succeeds --
+// atomically writes it back.
+func withOCIRegistryLock(ctx context.Context, settings *cli.EnvSettings, fn func(*ociRegistryFile) error) error {
+	path := ociRegistriesPath(settings)
+
+	return withFileLock(ctx, path, func() error {
+		file, err := loadOCIRegistryFile(settings)
+		if err != nil {
+			return err
+		}
+
+		if err := fn(file); err != nil {
+			return err
+		}
+
+		data, err := yaml.Marshal(file)
+		if err != nil {
+			return err
+		}
+
+		if err := os.MkdirAll(filepath.Dir(path), defaultNewConfigFolderMode); err != nil {
+			return err
+		}
+
+		return atomicWriteFile(path, defaultNewConfigFileMode, data)
+	})
+}
+
+// update adds or replaces the registry with the given name.
+func (f *ociRegistryFile) update(entry *OCIRegistryEntry) {
+	for i, existing := range f.Registries {
+		if existing.Name == entry.Name {
+			f.Registries[i] = entry
+			return
+		}
+	}
+
+	f.Registries = append(f.Registries, entry)
+}
+
+// remove deletes the registry with the given name, reporting whether it was
+// found.
+func (f *ociRegistryFile) remove(name string) bool {
+	for i, existing := range f.Registries {
+		if existing.Name == name {
+			f.Registries = append(f.Registries[:i], f.Registries[i+1:]...)
+			return true
+		}
+	}
+
+	return false
+}
+
+const ociPingTimeout = 10 * time.Second
+
+// authChallenge is a parsed WWW-Authenticate header, e.g.
+// `Bearer realm="https://auth.example.com/token",service="registry.example.com",scope="repository:charts:pull"`.
+type authChallenge struct {
+	Scheme string
+	Params map[string]string
+}
+
+// parseWWWAuthenticate splits a WWW-Authenticate header into its auth
+// scheme and its comma-separated key="value" parameters, e.g. the scheme
+// "Bearer" and params {realm, service, scope} out of
+// `Bearer realm="https://auth.example.com/token",service="registry.example.com",scope="repository:charts:pull"`.
+func parseWWWAuthenticate(header string) (*authChallenge, error) {
+	scheme, rest, found := strings.Cut(header, " ")
+	if !found {
+		return nil, fmt.Errorf("malformed WWW-Authenticate header: %q", header)
+	}
+
+	params := map[string]string{}
+
+	for _, part := range splitAuthParams(rest) {
+		key, value, found := strings.Cut(part, "=")
+		if !found {
+			continue
+		}
+
+		params[strings.TrimSpace(key)] = strings.Trim(strings.TrimSpace(value), `"`)
+	}
+
+	return &authChallenge{Scheme: scheme, Params: params}, nil
+}
+
+// splitAuthParams splits a comma-separated list of key="value" pairs,
+// respecting commas that appear inside quoted values.
+func splitAuthParams(s string) []string {
+	var parts []string
+
+	var inQuotes bool
+
+	start := 0
+
+	for i, r := range s {
+		switch r {
+		case '"':
+			inQuotes = !inQuotes
+		case ',':
+			if !inQuotes {
+				parts = append(parts, strings.TrimSpace(s[start:i]))
+				start = i + 1
+			}
+		}
+	}
+
+	parts = append(parts, strings.TrimSpace(s[start:]))
+
+	return parts
+}
+
+// bearerTokenResponse is the JSON body returned by a registry token
+// endpoint; registries vary on which of these two fields they populate.
+type bearerTokenResponse struct {
+	Token       string `json:"token"`
+	AccessToken string `json:"access_token"`
+}
+
+// fetchBearerToken exchanges the stored basic credentials for a bearer
+// token at the realm named by the challenge.
+func fetchBearerToken(challenge *authChallenge, creds *RepoCredentials) (string, error) {
+	realm := challenge.Params["realm"]
+	if realm == "" {
+		return "", fmt.Errorf("WWW-Authenticate challenge missing realm")
+	}
+
+	tokenURL, err := url.Parse(realm)
+	if err != nil {
+		return "", err
+	}
+
+	query := tokenURL.Query()
+	if service := challenge.Params["service"]; service != "" {
+		query.Set("service", service)
+	}
+
+	if scope := challenge.Params["scope"]; scope != "" {
+		query.Set("scope", scope)
+	}
+
+	tokenURL.RawQuery = query.Encode()
+
+	ctx, cancel := context.WithTimeout(context.Background(), ociPingTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, tokenURL.String(), nil)
+	if err != nil {
+		return "", err
+	}
+
+	if creds != nil && creds.Username != "" {
+		req.SetBasicAuth(creds.Username, creds.Password)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to fetch registry token: status %d", resp.StatusCode)
+	}
+
+	var tokenResp bearerTokenResponse
+	if err := json.NewDecoder(resp.Body).Decode(&tokenResp); err != nil {
+		return "", err
+	}
+
+	if tokenResp.Token != "" {
+		return tokenResp.Token, nil
+	}
+
+	return tokenResp.AccessToken, nil
+}
+
+// applyCredentials sets the Authorization header for req from creds,
+// preferring a bearer token over basic auth when both are present.
+func applyCredentials(req *http.Request, creds *RepoCredentials) {
+	if creds == nil {
+		return
+	}
+
+	switch {
+	case creds.BearerToken != "":
+		req.Header.Set("Authorization", "Bearer "+creds.BearerToken)
+	case creds.Username != "":
+		req.SetBasicAuth(creds.Username, creds.Password)
+	}
+}
+
+func doOCIRequest(pingURL string, creds *RepoCredentials) (*http.Response, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), ociPingTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, pingURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	applyCredentials(req, creds)
+
+	return http.DefaultClient.Do(req)
+}
+
+// pingOCIRegistry performs a lightweight GET /v2/ against the registry host
+// to confirm it exists and speaks the OCI distribution API, since there's no
+// index.yaml to download for validation. On a 401 it follows the
+// WWW-Authenticate challenge to fetch a bearer token and retries once.
+func pingOCIRegistry(registryURL string, creds *RepoCredentials) error {
+	host := ociRegistryHost(registryURL)
+
+	pingURL := fmt.Sprintf("https://%s/v2/", host)
+
+	resp, err := doOCIRequest(pingURL, creds)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+
+	if resp.StatusCode != http.StatusUnauthorized {
+		return fmt.Errorf("oci registry %q returned unexpected status %d", host, resp.StatusCode)
+	}
+
+	challenge, err := parseWWWAuthenticate(resp.Header.Get("WWW-Authenticate"))
+	if err != nil {
+		// No bearer challenge to follow; a bare 401 at least still proves
+		// the registry exists and requires auth we haven't supplied.
+		return nil
+	}
+
+	if !strings.EqualFold(challenge.Scheme, "Bearer") {
+		return fmt.Errorf("unsupported oci auth scheme %q", challenge.Scheme)
+	}
+
+	token, err := fetchBearerToken(challenge, creds)
+	if err != nil {
+		return err
+	}
+
+	resp2, err := doOCIRequest(pingURL, &RepoCredentials{BearerToken: token})
+	if err != nil {
+		return err
+	}
+	defer resp2.Body.Close()
+
+	if resp2.StatusCode != http.StatusOK {
+		return fmt.Errorf("oci registry %q rejected token exchange with status %d", host, resp2.StatusCode)
+	}
+
+	return nil
+}
+
+// addOCIRegistry validates and stores an OCI registry entry.
+func addOCIRegistry(request AddUpdateRepoRequest, settings *cli.EnvSettings) error {
+	creds := credentialsFromRequest(request)
+
+	if err := pingOCIRegistry(request.URL, creds); err != nil {
+		zlog.Error().Err(err).Str("action", "add_repo").Msg("failed to reach oci registry")
+		return err
+	}
+
+	lockCtx, cancel := context.WithTimeout(context.Background(), timeoutForLock)
+	defer cancel()
+
+	err := withOCIRegistryLock(lockCtx, settings, func(file *ociRegistryFile) error {
+		file.update(&OCIRegistryEntry{Name: request.Name, URL: request.URL})
+		return nil
+	})
+	if err != nil {
+		zlog.Error().Err(err).Str("action", "add_repo").Msg("failed to write oci registries file")
+		return err
+	}
+
+	if err := storeCredentials(settings, request.Name, creds); err != nil {
+		zlog.Error().Err(err).Str("action", "add_repo").Msg("failed to store repository credentials")
+		return err
+	}
+
+	return nil
+}
+
+// updateOCIRegistry replaces the URL of an existing OCI registry entry.
+// Credentials are left untouched by an update (use UpdateRepoCredentials to
+// rotate them), so when the request doesn't supply new ones the registry's
+// previously stored credentials are used to validate the new URL instead of
+// pinging anonymously.
+func updateOCIRegistry(request AddUpdateRepoRequest, settings *cli.EnvSettings) error {
+	creds := credentialsFromRequest(request)
+
+	if creds.isEmpty() {
+		stored, err := loadCredentials(settings, request.Name)
+		if err != nil {
+			zlog.Error().Err(err).Str("action", "update_repository").Msg("failed to load stored credentials")
+			return err
+		}
+
+		creds = stored
+	}
+
+	if err := pingOCIRegistry(request.URL, creds); err != nil {
+		zlog.Error().Err(err).Str("action", "update_repository").Msg("failed to reach oci registry")
+		return err
+	}
+
+	lockCtx, cancel := context.WithTimeout(context.Background(), timeoutForLock)
+	defer cancel()
+
+	err := withOCIRegistryLock(lockCtx, settings, func(file *ociRegistryFile) error {
+		file.update(&OCIRegistryEntry{Name: request.Name, URL: request.URL})
+		return nil
+	})
+	if err != nil {
+		zlog.Error().Err(err).Str("action", "update_repository").Msg("failed to write oci registries file")
+		return err
+	}
+
+	return nil
+}
+
+// removeOCIRegistry deletes an OCI registry entry by name. Returns an error
+// if the name isn't a known OCI registry.
+func removeOCIRegistry(name string, settings *cli.EnvSettings) error {
+	lockCtx, cancel := context.WithTimeout(context.Background(), timeoutForLock)
+	defer cancel()
+
+	err := withOCIRegistryLock(lockCtx, settings, func(file *ociRegistryFile) error {
+		if !file.remove(name) {
+			return fmt.Errorf("repository %q not found", name)
+		}
+
+		return nil
+	})
+	if err != nil {
+		zlog.Error().Err(err).Str("action", "remove_repo").Msg("failed to remove oci registry")
+		return err
+	}
+
+	return nil
+}
+
+// listOCIRegistries returns the registered OCI registries.
+func listOCIRegistries(settings *cli.EnvSettings) ([]*OCIRegistryEntry, error) {
+	file, err := loadOCIRegistryFile(settings)
+	if err != nil {
+		zlog.Error().Err(err).Str("action", "list_repo").Msg("failed to read oci registries file")
+		return nil, err
+	}
+
+	return file.Registries, nil
+}
+
+// findOCIRegistry returns the registered OCI registry with the given name,
+// or false if name isn't one.
+func findOCIRegistry(settings *cli.EnvSettings, name string) (*OCIRegistryEntry, bool, error) {
+	registries, err := listOCIRegistries(settings)
+	if err != nil {
+		return nil, false, err
+	}
+
+	for _, entry := range registries {
+		if entry.Name == name {
+			return entry, true, nil
+		}
+	}
+
+	return nil, false, nil
+}
+
+// listOCIChartVersions lists the tags published for chartName at
+// registryURL using Helm's own OCI registry client -- the same client
+// `helm push`/`helm pull` use -- rather than the getter.All HTTP providers
+// classic repositories resolve through. OCI registries have no index.yaml,
+// so this is the only way to discover a chart's available versions.
+func listOCIChartVersions(registryURL, chartName string, creds *RepoCredentials) ([]string, error) {
+	client, err := registry.NewClient()
+	if err != nil {
+		return nil, err
+	}
+
+	if creds != nil && creds.Username != "" {
+		loginOpt := registry.LoginOptBasicAuth(creds.Username, creds.Password)
+
+		if err := client.Login(ociRegistryHost(registryURL), loginOpt); err != nil {
+			return nil, err
+		}
+	}
+
+	return client.Tags(ociChartRef(registryURL, chartName))
+}
@@ -0,0 +1,119 @@
+package helm
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestIsOCIRepository(t *testing.T) {
+	tests := []struct {
+		url  string
+		want bool
+	}{
+		{"oci://registry.example.com/charts", true},
+		{"https://charts.example.com", false},
+		{"", false},
+	}
+
+	for _, tt := range tests {
+		if got := isOCIRepository(tt.url); got != tt.want {
+			t.Errorf("isOCIRepository(%q) = %v, want %v", tt.url, got, tt.want)
+		}
+	}
+}
+
+func TestOCIRegistryHost(t *testing.T) {
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"oci://registry.example.com/charts", "registry.example.com"},
+		{"oci://registry.example.com", "registry.example.com"},
+		{"oci://registry.example.com:5000/a/b/c", "registry.example.com:5000"},
+	}
+
+	for _, tt := range tests {
+		if got := ociRegistryHost(tt.url); got != tt.want {
+			t.Errorf("ociRegistryHost(%q) = %q, want %q", tt.url, got, tt.want)
+		}
+	}
+}
+
+func TestOCIChartRef(t *testing.T) {
+	tests := []struct {
+		registryURL string
+		chartName   string
+		want        string
+	}{
+		{"oci://registry.example.com/charts", "nginx", "registry.example.com/charts/nginx"},
+		{"oci://registry.example.com/charts/", "nginx", "registry.example.com/charts/nginx"},
+		{"oci://registry.example.com", "nginx", "registry.example.com/nginx"},
+	}
+
+	for _, tt := range tests {
+		if got := ociChartRef(tt.registryURL, tt.chartName); got != tt.want {
+			t.Errorf("ociChartRef(%q, %q) = %q, want %q", tt.registryURL, tt.chartName, got, tt.want)
+		}
+	}
+}
+
+func TestSplitAuthParams(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  []string
+	}{
+		{
+			name:  "simple",
+			input: `realm="https://auth.example.com/token",service="registry.example.com"`,
+			want:  []string{`realm="https://auth.example.com/token"`, `service="registry.example.com"`},
+		},
+		{
+			name:  "comma inside quoted value is not a separator",
+			input: `scope="repository:charts:pull,push",service="registry.example.com"`,
+			want:  []string{`scope="repository:charts:pull,push"`, `service="registry.example.com"`},
+		},
+		{
+			name:  "single param",
+			input: `realm="https://auth.example.com/token"`,
+			want:  []string{`realm="https://auth.example.com/token"`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := splitAuthParams(tt.input); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("splitAuthParams(%q) = %#v, want %#v", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseWWWAuthenticate(t *testing.T) {
+	header := `Bearer realm="https://auth.example.com/token",service="registry.example.com",scope="repository:charts:pull"`
+
+	challenge, err := parseWWWAuthenticate(header)
+	if err != nil {
+		t.Fatalf("parseWWWAuthenticate returned error: %v", err)
+	}
+
+	if challenge.Scheme != "Bearer" {
+		t.Errorf("Scheme = %q, want %q", challenge.Scheme, "Bearer")
+	}
+
+	wantParams := map[string]string{
+		"realm":   "https://auth.example.com/token",
+		"service": "registry.example.com",
+		"scope":   "repository:charts:pull",
+	}
+
+	if !reflect.DeepEqual(challenge.Params, wantParams) {
+		t.Errorf("Params = %#v, want %#v", challenge.Params, wantParams)
+	}
+}
+
+func TestParseWWWAuthenticateMalformed(t *testing.T) {
+	if _, err := parseWWWAuthenticate("malformed-header-with-no-space"); err == nil {
+		t.Error("expected an error for a header with no scheme/params separator")
+	}
+}
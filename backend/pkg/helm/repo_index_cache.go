@@ -0,0 +1,257 @@
+package helm
+
+// RepoIndexCache keeps the parsed index.yaml of every registered HTTP chart
+// repository in memory, refreshed periodically in the background, so chart
+// search doesn't have to re-download and re-parse YAML on every request.
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	zlog "github.com/rs/zerolog/log"
+	"golang.org/x/sync/singleflight"
+	"helm.sh/helm/v3/pkg/cli"
+	"helm.sh/helm/v3/pkg/getter"
+	"helm.sh/helm/v3/pkg/repo"
+)
+
+// DefaultRefreshInterval is how often the background refresher re-downloads
+// every registered repository's index, absent an explicit interval.
+const DefaultRefreshInterval = 10 * time.Minute
+
+// RepoIndexCache holds the parsed index.yaml of every registered HTTP chart
+// repository. It should be created once and shared by the Handler.
+type RepoIndexCache struct {
+	mu      sync.RWMutex
+	indexes map[string]*repo.IndexFile
+
+	group singleflight.Group
+}
+
+// NewRepoIndexCache creates an empty RepoIndexCache.
+func NewRepoIndexCache() *RepoIndexCache {
+	return &RepoIndexCache{
+		indexes: map[string]*repo.IndexFile{},
+	}
+}
+
+// Get returns the cached index for a repository, if one has been
+// downloaded yet.
+func (c *RepoIndexCache) Get(name string) (*repo.IndexFile, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	index, ok := c.indexes[name]
+
+	return index, ok
+}
+
+// All returns every cached index, keyed by repository name.
+func (c *RepoIndexCache) All() map[string]*repo.IndexFile {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	indexes := make(map[string]*repo.IndexFile, len(c.indexes))
+	for name, index := range c.indexes {
+		indexes[name] = index
+	}
+
+	return indexes
+}
+
+func (c *RepoIndexCache) set(name string, index *repo.IndexFile) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.indexes[name] = index
+}
+
+// Remove evicts the cached index for name, if any. Call it once a
+// repository or OCI registry has actually been removed, so search/version
+// lookups stop serving its last-downloaded index.
+func (c *RepoIndexCache) Remove(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.indexes, name)
+}
+
+// refreshOne re-downloads and re-parses a single repository's index.yaml,
+// deduplicating concurrent refreshes of the same repo via singleflight.
+func (c *RepoIndexCache) refreshOne(entry *repo.Entry, settings *cli.EnvSettings) error {
+	_, err, _ := c.group.Do(entry.Name, func() (interface{}, error) {
+		entryWithCreds, err := withCredentials(entry, settings)
+		if err != nil {
+			return nil, err
+		}
+
+		chartRepo, err := repo.NewChartRepository(entryWithCreds, getter.All(settings))
+		if err != nil {
+			return nil, err
+		}
+
+		indexPath, err := chartRepo.DownloadIndexFile()
+		if err != nil {
+			return nil, err
+		}
+
+		index, err := repo.LoadIndexFile(indexPath)
+		if err != nil {
+			return nil, err
+		}
+
+		c.set(entry.Name, index)
+
+		return nil, nil
+	})
+
+	return err
+}
+
+// RefreshRepositories re-downloads the index.yaml of each named repository
+// (or every registered repository, when names is empty), concurrently. A
+// failure refreshing one repository doesn't prevent the others from
+// refreshing; the per-repo outcome is returned in the result map.
+func (c *RepoIndexCache) RefreshRepositories(names []string, settings *cli.EnvSettings) (map[string]error, error) {
+	lockCtx, cancel := context.WithTimeout(context.Background(), timeoutForLock)
+	defer cancel()
+
+	var entries []*repo.Entry
+
+	err := withRepoReadLock(lockCtx, settings, func(repoFile *repo.File) error {
+		entries = repoFile.Repositories
+		return nil
+	})
+	if err != nil {
+		zlog.Error().Err(err).Str("action", "refresh_repos").Msg("failed to read repo file")
+		return nil, err
+	}
+
+	if len(names) > 0 {
+		wanted := make(map[string]bool, len(names))
+		for _, name := range names {
+			wanted[name] = true
+		}
+
+		filtered := make([]*repo.Entry, 0, len(names))
+
+		for _, entry := range entries {
+			if wanted[entry.Name] {
+				filtered = append(filtered, entry)
+			}
+		}
+
+		entries = filtered
+	}
+
+	results := make(map[string]error, len(entries))
+
+	var (
+		resultsMu sync.Mutex
+		wg        sync.WaitGroup
+	)
+
+	for _, entry := range entries {
+		entry := entry
+
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			refreshErr := c.refreshOne(entry, settings)
+
+			resultsMu.Lock()
+			results[entry.Name] = refreshErr
+			resultsMu.Unlock()
+
+			if refreshErr != nil {
+				zlog.Error().Err(refreshErr).Str("action", "refresh_repos").Str("repo", entry.Name).
+					Msg("failed to refresh repository index")
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	return results, nil
+}
+
+// Run starts a background goroutine that refreshes every registered
+// repository on a fixed interval, stopping when ctx is cancelled. Intended
+// to be called once, from the Handler constructor.
+func (c *RepoIndexCache) Run(ctx context.Context, settings *cli.EnvSettings, interval time.Duration) {
+	if interval <= 0 {
+		interval = DefaultRefreshInterval
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if _, err := c.RefreshRepositories(nil, settings); err != nil {
+					zlog.Error().Err(err).Str("action", "refresh_repos").Msg("background repository refresh failed")
+				}
+			}
+		}
+	}()
+}
+
+// RefreshRepositoriesRequest optionally names which repositories to
+// refresh; an empty/omitted list refreshes all of them.
+type RefreshRepositoriesRequest struct {
+	Names []string `json:"names"`
+}
+
+// RefreshRepositoriesResponse reports the outcome of refreshing each
+// requested repository, since one repo's failure shouldn't hide the others'
+// success.
+type RefreshRepositoriesResponse struct {
+	Results map[string]string `json:"results"`
+}
+
+// Refresh the index.yaml of the given repositories (or all of them).
+func (h *Handler) RefreshRepositories(w http.ResponseWriter, r *http.Request) {
+	var request RefreshRepositoriesRequest
+
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			zlog.Error().Err(err).Str("action", "refresh_repos").Msg("failed to parse request")
+			http.Error(w, err.Error(), http.StatusBadRequest)
+
+			return
+		}
+	}
+
+	results, err := h.IndexCache.RefreshRepositories(request.Names, h.EnvSettings)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	response := RefreshRepositoriesResponse{Results: make(map[string]string, len(results))}
+
+	for name, refreshErr := range results {
+		if refreshErr != nil {
+			response.Results[name] = refreshErr.Error()
+		} else {
+			response.Results[name] = "success"
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		zlog.Error().Err(err).Str("action", "refresh_repos").Msg("failed to encode response")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
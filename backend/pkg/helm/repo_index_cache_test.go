@@ -0,0 +1,25 @@
+package helm
+
+import (
+	"testing"
+
+	"helm.sh/helm/v3/pkg/repo"
+)
+
+func TestRepoIndexCacheRemove(t *testing.T) {
+	cache := NewRepoIndexCache()
+	cache.set("stable", &repo.IndexFile{})
+
+	if _, ok := cache.Get("stable"); !ok {
+		t.Fatalf("expected \"stable\" to be cached before Remove")
+	}
+
+	cache.Remove("stable")
+
+	if _, ok := cache.Get("stable"); ok {
+		t.Errorf("expected \"stable\" to be evicted after Remove")
+	}
+
+	// Removing a name that was never cached is a no-op, not an error.
+	cache.Remove("never-added")
+}
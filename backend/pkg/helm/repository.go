@@ -6,11 +6,8 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
-	"strings"
 	"time"
 
-	"github.com/gofrs/flock"
-
 	zlog "github.com/rs/zerolog/log"
 	"helm.sh/helm/v3/pkg/cli"
 	"helm.sh/helm/v3/pkg/getter"
@@ -25,10 +22,25 @@ const (
 // add repository.
 type AddUpdateRepoRequest struct {
 	Name string `json:"name"`
-	URL  string `json:"url"`
-	// TODO: Figure out how to support auth
-	// like username, password, certfile etc
-	// https://github.com/helm/helm/blob/39ca699ca790e02ba36753dec6ba4177cc68d417/cmd/helm/repo_add.go#L169
+	// URL is either a classic HTTP chart repository URL or an OCI
+	// reference, e.g. oci://registry.example.com/charts.
+	URL string `json:"url"`
+
+	// Auth, optional. Username/Password/PassCredentialsAll/CAFile/CertFile/
+	// KeyFile/InsecureSkipTLSverify apply to classic HTTP repositories and
+	// mirror the fields helm's own `repo add` command supports. For OCI
+	// registries, Username/Password are used for basic auth and for the
+	// token-exchange flow, and BearerToken, when set, is sent as-is in the
+	// Authorization header of the registry ping. Classic repositories ignore
+	// BearerToken.
+	Username              string `json:"username,omitempty"`
+	Password              string `json:"password,omitempty"`
+	PassCredentialsAll    bool   `json:"passCredentialsAll,omitempty"`
+	CAFile                string `json:"caFile,omitempty"`
+	CertFile              string `json:"certFile,omitempty"`
+	KeyFile               string `json:"keyFile,omitempty"`
+	InsecureSkipTLSverify bool   `json:"insecureSkipTLSverify,omitempty"`
+	BearerToken           string `json:"bearerToken,omitempty"`
 }
 
 // Creates a filename if it's not there, including any missing directories.
@@ -43,88 +55,64 @@ func createFileIfNotThere(fileName string) error {
 	return nil
 }
 
-// Uses a file lock like the helm tool.
-func lockRepositoryFile(lockCtx context.Context, repositoryConfig string) (bool, *flock.Flock, error) {
-	var lockPath string
-
-	repoFileExt := filepath.Ext(repositoryConfig)
-
-	if len(repoFileExt) > 0 && len(repoFileExt) < len(repositoryConfig) {
-		lockPath = strings.Replace(repositoryConfig, repoFileExt, ".lock", 1)
-	} else {
-		lockPath = repositoryConfig + ".lock"
-	}
-
-	fileLock := flock.New(lockPath)
-
-	locked, err := fileLock.TryLockContext(lockCtx, time.Second)
-
-	return locked, fileLock, err
-}
-
 const timeoutForLock = 30 * time.Second
 
-// Adds a repository with name, url to the helm config. Returns error if there is one.
-func AddRepository(name string, url string, settings *cli.EnvSettings) error {
-	err := createFileIfNotThere(settings.RepositoryConfig)
-	if err != nil {
-		zlog.Error().Err(err).Str("action", "add_repo").Msg("failed to create empty RepositoryConfig file")
-		return err
+// Adds a repository to the helm config. Returns error if there is one.
+// OCI references (oci://...) are stored separately from classic HTTP chart
+// repositories, since they have no index.yaml to download.
+func AddRepository(request AddUpdateRepoRequest, settings *cli.EnvSettings) error {
+	if isOCIRepository(request.URL) {
+		return addOCIRegistry(request, settings)
 	}
 
-	lockCtx, cancel := context.WithTimeout(context.Background(), timeoutForLock)
-	defer cancel()
-
-	locked, fileLock, err := lockRepositoryFile(lockCtx, settings.RepositoryConfig)
-	if err == nil && locked {
-		defer func() {
-			err := fileLock.Unlock()
-			if err != nil {
-				zlog.Error().Err(err).Str("action", "add_repo").Msg("failed to unlock repository config file")
-			}
-		}()
+	// newRepo is what gets written to repository.yaml; it carries no
+	// secrets, which are kept in the encrypted credentials sidecar instead.
+	newRepo := &repo.Entry{
+		Name: request.Name,
+		URL:  request.URL,
 	}
 
-	if err != nil {
-		zlog.Error().Err(err).Str("action", "add_repo").Msg("failed to lock repository config file")
-		return err
-	}
+	creds := credentialsFromRequest(request)
 
-	// read repo file
-	repoFile, err := repo.LoadFile(settings.RepositoryConfig)
-	if err != nil {
-		zlog.Error().Err(err).Str("action", "add_repo").Msg("failed to read repo file")
-		return err
-	}
+	entryWithCreds := *newRepo
+	entryWithCreds.Username = creds.Username
+	entryWithCreds.Password = creds.Password
+	entryWithCreds.PassCredentialsAll = creds.PassCredentialsAll
+	entryWithCreds.CAFile = creds.CAFile
+	entryWithCreds.CertFile = creds.CertFile
+	entryWithCreds.KeyFile = creds.KeyFile
+	entryWithCreds.InsecureSkipTLSverify = creds.InsecureSkipTLSverify
 
-	// add repo
-	newRepo := &repo.Entry{
-		Name: name,
-		URL:  url,
-	}
-
-	repo, err := repo.NewChartRepository(newRepo, getter.All(settings))
+	chartRepo, err := repo.NewChartRepository(&entryWithCreds, getter.All(settings))
 	if err != nil {
 		zlog.Error().Err(err).Str("action", "add_repo").Msg("failed to create chart repository")
 		return err
 	}
 
 	// download chart repo index
-	_, err = repo.DownloadIndexFile()
+	_, err = chartRepo.DownloadIndexFile()
 	if err != nil {
 		zlog.Error().Err(err).Str("action", "add_repo").Msg("failed to download index file")
 		return err
 	}
 
-	// write repo file
-	repoFile.Update(newRepo)
+	lockCtx, cancel := context.WithTimeout(context.Background(), timeoutForLock)
+	defer cancel()
 
-	err = repoFile.WriteFile(settings.RepositoryConfig, defaultNewConfigFileMode)
+	err = withRepoLock(lockCtx, settings, func(repoFile *repo.File) error {
+		repoFile.Update(newRepo)
+		return nil
+	})
 	if err != nil {
 		zlog.Error().Err(err).Str("action", "add_repo").Msg("failed to write repo file")
 		return err
 	}
 
+	if err := storeCredentials(settings, request.Name, creds); err != nil {
+		zlog.Error().Err(err).Str("action", "add_repo").Msg("failed to store repository credentials")
+		return err
+	}
+
 	return nil
 }
 
@@ -140,12 +128,22 @@ func (h *Handler) AddRepo(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = AddRepository(request.Name, request.URL, h.EnvSettings)
+	err = AddRepository(request, h.EnvSettings)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	// Warm the index cache for the new repository right away, so its charts
+	// show up in search/version listing immediately instead of waiting for
+	// the background refresher's next tick (OCI registries aren't cached,
+	// so there's nothing to warm for those).
+	if !isOCIRepository(request.URL) {
+		if _, err := h.IndexCache.RefreshRepositories([]string{request.Name}, h.EnvSettings); err != nil {
+			zlog.Error().Err(err).Str("action", "add_repo").Msg("failed to warm index cache for new repository")
+		}
+	}
+
 	// respond
 	response := map[string]string{
 		"message": "success",
@@ -163,10 +161,20 @@ func (h *Handler) AddRepo(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// repoType distinguishes classic HTTP chart repositories from OCI
+// registries in API responses, since the UI renders them differently.
+type repoType string
+
+const (
+	repoTypeHTTP repoType = "http"
+	repoTypeOCI  repoType = "oci"
+)
+
 // List repository.
 type repositoryInfo struct {
-	Name string `json:"name"`
-	URL  string `json:"url"`
+	Name string   `json:"name"`
+	URL  string   `json:"url"`
+	Type repoType `json:"type"`
 }
 type ListRepoResponse struct {
 	Repositories []repositoryInfo `json:"repositories"`
@@ -182,28 +190,40 @@ func createFullPath(p string) (*os.File, error) {
 }
 
 func listRepositories(settings *cli.EnvSettings) ([]repositoryInfo, error) {
-	err := createFileIfNotThere(settings.RepositoryConfig)
+	var repositories []repositoryInfo
+
+	lockCtx, cancel := context.WithTimeout(context.Background(), timeoutForLock)
+	defer cancel()
+
+	err := withRepoReadLock(lockCtx, settings, func(repoFile *repo.File) error {
+		repositories = make([]repositoryInfo, 0, len(repoFile.Repositories))
+
+		for _, entry := range repoFile.Repositories {
+			repositories = append(repositories, repositoryInfo{
+				Name: entry.Name,
+				URL:  entry.URL,
+				Type: repoTypeHTTP,
+			})
+		}
+
+		return nil
+	})
 	if err != nil {
-		zlog.Error().Err(err).Str("action", "list_repo").Msg("failed to create empty RepositoryConfig file")
+		zlog.Error().Err(err).Str("action", "list_repo").Msg("failed to read repo file")
 		return nil, err
 	}
 
-	// read repo file
-	repoFile, err := repo.LoadFile(settings.RepositoryConfig)
+	ociRegistries, err := listOCIRegistries(settings)
 	if err != nil {
-		zlog.Error().Err(err).Str("action", "list_repo").Msg("failed to read repo file")
+		zlog.Error().Err(err).Str("action", "list_repo").Msg("failed to list oci registries")
 		return nil, err
 	}
 
-	// response
-	repositories := make([]repositoryInfo, 0, len(repoFile.Repositories))
-
-	for _, repo := range repoFile.Repositories {
-		repo := repo
-
+	for _, registry := range ociRegistries {
 		repositories = append(repositories, repositoryInfo{
-			Name: repo.Name,
-			URL:  repo.URL,
+			Name: registry.Name,
+			URL:  registry.URL,
+			Type: repoTypeOCI,
 		})
 	}
 
@@ -233,42 +253,33 @@ func (h *Handler) ListRepo(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// RemoveRepository removes the classic HTTP chart repository or OCI
+// registry with the given name, whichever it is registered as, along with
+// any credentials stored for it.
 func RemoveRepository(name string, settings *cli.EnvSettings) error {
-	err := createFileIfNotThere(settings.RepositoryConfig)
-	if err != nil {
-		zlog.Error().Err(err).Str("action", "remove_repo").Msg("failed to create empty RepositoryConfig file")
-		return err
-	}
-
 	lockCtx, cancel := context.WithTimeout(context.Background(), timeoutForLock)
 	defer cancel()
 
-	locked, fileLock, err := lockRepositoryFile(lockCtx, settings.RepositoryConfig)
-	if err == nil && locked {
-		defer func() {
-			err := fileLock.Unlock()
-			if err != nil {
-				zlog.Error().Err(err).Str("action", "add_repo").Msg("failed to unlock repository config file")
-			}
-		}()
-	}
+	var foundClassicRepo bool
 
-	repoFile, err := repo.LoadFile(settings.RepositoryConfig)
+	err := withRepoLock(lockCtx, settings, func(repoFile *repo.File) error {
+		foundClassicRepo = repoFile.Remove(name)
+		return nil
+	})
 	if err != nil {
-		zlog.Error().Err(err).Str("action", "remove_repo").Msg("failed to read repo file")
+		zlog.Error().Err(err).Str("action", "remove_repo").Msg("failed to remove repository")
 		return err
 	}
 
-	isRemoved := repoFile.Remove(name)
-	if !isRemoved {
-		zlog.Error().Err(err).Str("action", "remove_repo").Msg("repository not found")
-		return err
+	if !foundClassicRepo {
+		// Not a classic repository; it may be a registered OCI registry.
+		if err := removeOCIRegistry(name, settings); err != nil {
+			return err
+		}
 	}
 
-	// write repo file
-	err = repoFile.WriteFile(settings.RepositoryConfig, defaultNewConfigFileMode)
-	if err != nil {
-		zlog.Error().Err(err).Str("action", "remove_repo").Msg("failed to write repo file")
+	if err := deleteCredentials(settings, name); err != nil {
+		zlog.Error().Err(err).Str("action", "remove_repo").Msg("failed to delete stored credentials")
 		return err
 	}
 
@@ -285,44 +296,35 @@ func (h *Handler) RemoveRepo(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// The repo is gone from disk; drop its cached index too, so search and
+	// version listing stop serving charts from a repo that no longer exists.
+	h.IndexCache.Remove(name)
+
 	w.WriteHeader(http.StatusOK)
 }
 
-func UpdateRepository(name, url string, settings *cli.EnvSettings) error {
-	err := createFileIfNotThere(settings.RepositoryConfig)
-	if err != nil {
-		zlog.Error().Err(err).Str("action", "update_repository").Msg("failed to create empty RepositoryConfig file")
-		return err
+// UpdateRepository updates the URL of an existing classic HTTP chart
+// repository or OCI registry. Switching an entry between the two kinds
+// isn't supported; it must be removed and re-added instead. Credentials are
+// left untouched; use UpdateRepoCredentials to rotate them.
+func UpdateRepository(request AddUpdateRepoRequest, settings *cli.EnvSettings) error {
+	if isOCIRepository(request.URL) {
+		return updateOCIRegistry(request, settings)
 	}
 
 	lockCtx, cancel := context.WithTimeout(context.Background(), timeoutForLock)
 	defer cancel()
 
-	locked, fileLock, err := lockRepositoryFile(lockCtx, settings.RepositoryConfig)
-	if err == nil && locked {
-		defer func() {
-			err := fileLock.Unlock()
-			if err != nil {
-				zlog.Error().Err(err).Str("action", "update_repo").Msg("failed to unlock repository config file")
-			}
-		}()
-	}
-
-	repoFile, err := repo.LoadFile(settings.RepositoryConfig)
-	if err != nil {
-		zlog.Error().Err(err).Str("action", "update_repository").Msg("failed to read repo file")
-		return err
-	}
+	err := withRepoLock(lockCtx, settings, func(repoFile *repo.File) error {
+		repoFile.Update(&repo.Entry{
+			Name: request.Name,
+			URL:  request.URL,
+		})
 
-	// update repo
-	repoFile.Update(&repo.Entry{
-		Name: name,
-		URL:  url,
+		return nil
 	})
-
-	err = repoFile.WriteFile(settings.RepositoryConfig, defaultNewConfigFileMode)
 	if err != nil {
-		zlog.Error().Err(err).Str("action", "update_repository").Msg("failed to write repo file")
+		zlog.Error().Err(err).Str("action", "update_repository").Msg("failed to update repository")
 		return err
 	}
 
@@ -342,7 +344,36 @@ func (h *Handler) UpdateRepository(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = UpdateRepository(request.Name, request.URL, h.EnvSettings)
+	err = UpdateRepository(request, h.EnvSettings)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// UpdateRepoCredentials rotates the stored credentials for an existing
+// repository or OCI registry without needing to remove and re-add it.
+func UpdateRepoCredentials(name string, creds *RepoCredentials, settings *cli.EnvSettings) error {
+	return storeCredentials(settings, name, creds)
+}
+
+// Rotate the credentials of an existing repository name.
+func (h *Handler) UpdateRepoCredentials(w http.ResponseWriter, r *http.Request) {
+	// The request reuses AddUpdateRepoRequest's credential fields; URL is
+	// ignored since the repository itself isn't being modified.
+	var request AddUpdateRepoRequest
+
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		zlog.Error().Err(err).Str("action", "update_repo_credentials").Msg("failed to parse request")
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	err = UpdateRepoCredentials(request.Name, credentialsFromRequest(request), h.EnvSettings)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return